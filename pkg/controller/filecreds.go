@@ -0,0 +1,126 @@
+package controller
+
+import (
+	"fmt"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// annCAConfigMap names a ConfigMap in the pvc's namespace holding a CA bundle
+// to project alongside any file-based credentials, for endpoints whose
+// certificate isn't in the importer's default trust store.
+const annCAConfigMap = "cdi.kubevirt.io/caConfigMap"
+
+// fileCredentialsMountPath is the well-known path inside the importer
+// container under which file-based credentials are projected.
+const fileCredentialsMountPath = "/etc/cdi/credentials"
+
+// Well-known Secret/ConfigMap keys that, when present, are projected as files
+// instead of (or in addition to) the accessKeyId/secretKey env vars.
+const (
+	gcpServiceAccountKey = "gcp-service-account.json"
+	awsCredentialsKey    = "aws-credentials"
+	caBundleKey          = "ca.crt"
+	clientCertKey        = "client.pem"
+	clientKeyKey         = "client.key"
+)
+
+// fileCredentialProjection is everything makeImporterPodSpec needs to wire a
+// projected volume of file-based credentials into the importer container.
+type fileCredentialProjection struct {
+	Volume      *v1.Volume
+	VolumeMount v1.VolumeMount
+	Env         []v1.EnvVar
+}
+
+// buildFileCredentialProjection inspects the keys of secret (and, if set, the
+// ConfigMap named by the pvc's caConfigMap annotation) for well-known file-based
+// credential keys and, if any are found, returns a projected volume combining
+// both sources along with the env vars SDKs expect to find them at. If both
+// secret and the ConfigMap carry a CA bundle, the ConfigMap's is projected and
+// the Secret's is skipped, since the kubelet rejects a projected volume with
+// two sources writing to the same path. It returns nil if neither secret nor
+// the ConfigMap carries any of the recognized keys.
+func (c *Controller) buildFileCredentialProjection(secret *v1.Secret, pvc *v1.PersistentVolumeClaim) (*fileCredentialProjection, error) {
+	if secret == nil {
+		return nil, nil
+	}
+
+	var sources []v1.VolumeProjection
+	var env []v1.EnvVar
+	mode := int32(0400)
+
+	caBundleProjected := false
+	caBundleFromConfigMap := false
+
+	if cmName, found := pvc.Annotations[annCAConfigMap]; found && cmName != "" {
+		cm, err := c.clientset.CoreV1().ConfigMaps(pvc.Namespace).Get(cmName, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("buildFileCredentialProjection: error getting ConfigMap %q for pvc %s/%s: %v\n", cmName, pvc.Namespace, pvc.Name, err)
+		}
+		if _, found := cm.Data[caBundleKey]; found {
+			sources = append(sources, v1.VolumeProjection{
+				ConfigMap: &v1.ConfigMapProjection{
+					LocalObjectReference: v1.LocalObjectReference{Name: cmName},
+					Items:                []v1.KeyToPath{{Key: caBundleKey, Path: caBundleKey}},
+				},
+			})
+			caBundleProjected = true
+			caBundleFromConfigMap = true
+		}
+	}
+
+	secretItems := []v1.KeyToPath{}
+	for _, key := range []string{gcpServiceAccountKey, awsCredentialsKey, clientCertKey, clientKeyKey} {
+		if _, found := secret.Data[key]; found {
+			secretItems = append(secretItems, v1.KeyToPath{Key: key, Path: key})
+		}
+	}
+	if _, found := secret.Data[caBundleKey]; found && !caBundleFromConfigMap {
+		secretItems = append(secretItems, v1.KeyToPath{Key: caBundleKey, Path: caBundleKey})
+		caBundleProjected = true
+	}
+	if len(secretItems) > 0 {
+		sources = append(sources, v1.VolumeProjection{
+			Secret: &v1.SecretProjection{
+				LocalObjectReference: v1.LocalObjectReference{Name: secret.Name},
+				Items:                secretItems,
+			},
+		})
+	}
+
+	if len(sources) == 0 {
+		return nil, nil
+	}
+
+	if _, found := secret.Data[gcpServiceAccountKey]; found {
+		env = append(env, v1.EnvVar{Name: "GOOGLE_APPLICATION_CREDENTIALS", Value: fmt.Sprintf("%s/%s", fileCredentialsMountPath, gcpServiceAccountKey)})
+	}
+	if _, found := secret.Data[awsCredentialsKey]; found {
+		env = append(env, v1.EnvVar{Name: "AWS_SHARED_CREDENTIALS_FILE", Value: fmt.Sprintf("%s/%s", fileCredentialsMountPath, awsCredentialsKey)})
+	}
+	if caBundleProjected {
+		// projected regardless of whether the bundle came from the Secret or
+		// the caConfigMap ConfigMap, since both land at the same path.
+		env = append(env, v1.EnvVar{Name: "SSL_CERT_FILE", Value: fmt.Sprintf("%s/%s", fileCredentialsMountPath, caBundleKey)})
+	}
+
+	return &fileCredentialProjection{
+		Volume: &v1.Volume{
+			Name: "credentials",
+			VolumeSource: v1.VolumeSource{
+				Projected: &v1.ProjectedVolumeSource{
+					Sources:     sources,
+					DefaultMode: &mode,
+				},
+			},
+		},
+		VolumeMount: v1.VolumeMount{
+			Name:      "credentials",
+			MountPath: fileCredentialsMountPath,
+			ReadOnly:  true,
+		},
+		Env: env,
+	}, nil
+}
@@ -0,0 +1,72 @@
+package controller
+
+import (
+	"testing"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestBuildFileCredentialProjection_NoRecognizedKeys(t *testing.T) {
+	c := &Controller{}
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "endpoint-creds"},
+		Data: map[string][]byte{
+			"accessKeyId": []byte("AKIA..."),
+			"secretKey":   []byte("shh"),
+		},
+	}
+	pvc := &v1.PersistentVolumeClaim{}
+
+	projection, err := c.buildFileCredentialProjection(secret, pvc)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if projection != nil {
+		t.Errorf("expected no projection for a plain S3-style secret, got %+v", projection)
+	}
+}
+
+func TestBuildFileCredentialProjection_GCSAndCABundle(t *testing.T) {
+	c := &Controller{}
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "endpoint-creds"},
+		Data: map[string][]byte{
+			gcpServiceAccountKey: []byte("{}"),
+			caBundleKey:          []byte("-----BEGIN CERTIFICATE-----"),
+		},
+	}
+	pvc := &v1.PersistentVolumeClaim{}
+
+	projection, err := c.buildFileCredentialProjection(secret, pvc)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if projection == nil {
+		t.Fatal("expected a projection, got nil")
+	}
+
+	if projection.VolumeMount.MountPath != fileCredentialsMountPath {
+		t.Errorf("mount path = %q, want %q", projection.VolumeMount.MountPath, fileCredentialsMountPath)
+	}
+	if got := *projection.Volume.Projected.DefaultMode; got != 0400 {
+		t.Errorf("default mode = %#o, want 0400", got)
+	}
+
+	wantEnv := map[string]string{
+		"GOOGLE_APPLICATION_CREDENTIALS": fileCredentialsMountPath + "/" + gcpServiceAccountKey,
+		"SSL_CERT_FILE":                  fileCredentialsMountPath + "/" + caBundleKey,
+	}
+	gotEnv := map[string]string{}
+	for _, e := range projection.Env {
+		gotEnv[e.Name] = e.Value
+	}
+	for name, want := range wantEnv {
+		if gotEnv[name] != want {
+			t.Errorf("env %s = %q, want %q", name, gotEnv[name], want)
+		}
+	}
+	if len(gotEnv) != len(wantEnv) {
+		t.Errorf("got env %v, want exactly %v", gotEnv, wantEnv)
+	}
+}
@@ -0,0 +1,255 @@
+package controller
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// annMaxRetries lets a user cap how many times a failed import is retried,
+// overriding the controller-wide default.
+const annMaxRetries = "cdi.kubevirt.io/maxRetries"
+
+// annRetryCount tracks how many times the importer pod for a pvc has been
+// recreated after a failure.
+const annRetryCount = "cdi.kubevirt.io/retryCount"
+
+const (
+	// defaultMaxRetries is used when a pvc does not set annMaxRetries.
+	defaultMaxRetries = 3
+	// logTailLines bounds how much of a failed importer's log is copied into
+	// the Warning event recorded against the pvc.
+	logTailLines = 50
+)
+
+// podStatus returns the importer pod's PVC-visible status and, for a failed
+// pod, the exit code of its single container. A pod that hasn't reached a
+// terminal phase yet returns ("", 0).
+func podStatus(pod *v1.Pod) (status string, exitCode int32) {
+	switch pod.Status.Phase {
+	case v1.PodSucceeded:
+		return "Succeeded", 0
+	case v1.PodFailed:
+		for _, cs := range pod.Status.ContainerStatuses {
+			if cs.State.Terminated != nil {
+				exitCode = cs.State.Terminated.ExitCode
+			}
+		}
+		return "Failed", exitCode
+	default:
+		return "", 0
+	}
+}
+
+// onImporterPodChange is the informer event handler for pods annotated as
+// importer pods (annCreatedBy). It reconciles the owning pvc's status,
+// retries failed imports with backoff, and garbage-collects pods whose TTL
+// has elapsed.
+func (c *Controller) onImporterPodChange(pod *v1.Pod) error {
+	if pod.Annotations[annCreatedBy] != "yes" {
+		return nil
+	}
+
+	pvc, err := c.pvcForImporterPod(pod)
+	if err != nil {
+		return err
+	}
+	if pvc == nil {
+		// owning pvc is gone; the ownerReference on the pod means GC will
+		// clean it up on its own, nothing more to do here.
+		return nil
+	}
+
+	status, exitCode := podStatus(pod)
+	if status == "" {
+		return nil // still running
+	}
+
+	// the importer pod is done with its credentials either way; revoke and
+	// remove any ephemeral vault-creds secret now rather than leaving it
+	// usable until the pod itself is garbage-collected.
+	if err := c.cleanupVaultSecret(pvc); err != nil {
+		glog.Warningf("onImporterPodChange: %v\n", err)
+	}
+
+	if status == "Failed" {
+		c.recordImporterFailureEvent(pod, pvc, exitCode)
+		// check retry eligibility, and update the pvc's retry bookkeeping,
+		// before ever marking it Failed: retryImporterPod is the only writer
+		// to this pvc on this path, so there's no stale-resourceVersion
+		// conflict with a setPVCStatus call, and a pvc about to be retried
+		// never flaps through a Failed state.
+		retried, err := c.retryImporterPod(pod, pvc)
+		if err != nil {
+			return err
+		}
+		if retried {
+			return nil
+		}
+	}
+
+	updatedPVC, err := c.setPVCStatus(pvc, status)
+	if err != nil {
+		return fmt.Errorf("onImporterPodChange: error updating status of pvc %s/%s: %v\n", pvc.Namespace, pvc.Name, err)
+	}
+
+	return c.garbageCollectImporterPod(pod, updatedPVC)
+}
+
+// pvcForImporterPod looks up the pvc referenced by the "data-path" volume of
+// an importer pod. A nil result (no error) means the pvc no longer exists.
+func (c *Controller) pvcForImporterPod(pod *v1.Pod) (*v1.PersistentVolumeClaim, error) {
+	var claimName string
+	for _, vol := range pod.Spec.Volumes {
+		if vol.Name == "data-path" && vol.PersistentVolumeClaim != nil {
+			claimName = vol.PersistentVolumeClaim.ClaimName
+			break
+		}
+	}
+	if claimName == "" {
+		return nil, fmt.Errorf("pvcForImporterPod: importer pod %s/%s has no data-path volume\n", pod.Namespace, pod.Name)
+	}
+	pvc, err := c.clientset.CoreV1().PersistentVolumeClaims(pod.Namespace).Get(claimName, metav1.GetOptions{})
+	if apierrs.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("pvcForImporterPod: error getting pvc %s/%s: %v\n", pod.Namespace, claimName, err)
+	}
+	return pvc, nil
+}
+
+// recordImporterFailureEvent records a Warning event on pvc carrying the tail
+// of the failed importer pod's log, so a user can diagnose it without
+// needing direct pod access.
+func (c *Controller) recordImporterFailureEvent(pod *v1.Pod, pvc *v1.PersistentVolumeClaim, exitCode int32) {
+	tail, err := c.importerPodLogTail(pod)
+	if err != nil {
+		glog.Warningf("recordImporterFailureEvent: %v\n", err)
+		tail = "(log unavailable)"
+	}
+	c.recorder.Eventf(pvc, v1.EventTypeWarning, "ImportFailed", "importer pod %q exited %d:\n%s", pod.Name, exitCode, tail)
+}
+
+// importerPodLogTail returns the last logTailLines lines of the importer
+// container's log.
+func (c *Controller) importerPodLogTail(pod *v1.Pod) (string, error) {
+	tailLines := int64(logTailLines)
+	req := c.clientset.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &v1.PodLogOptions{TailLines: &tailLines})
+	raw, err := req.DoRaw()
+	if err != nil {
+		return "", fmt.Errorf("importerPodLogTail: error fetching logs for pod %s/%s: %v\n", pod.Namespace, pod.Name, err)
+	}
+	return string(raw), nil
+}
+
+// retryImporterPod recreates the importer pod for pvc after an exponential
+// backoff, unless the pvc's retry count has reached its maxRetries. Since the
+// importer pod name is deterministic (makeImporterPodSpec), the failed pod is
+// deleted here and now rather than left for createImporterPod to collide
+// with; its log tail was already captured in the failure event recorded by
+// the caller. The caller must not have written a terminal status annotation
+// onto pvc yet: this is the only place that updates pvc on the retry path, so
+// there is nothing for its Update call to conflict with. It returns true if a
+// retry was scheduled.
+func (c *Controller) retryImporterPod(pod *v1.Pod, pvc *v1.PersistentVolumeClaim) (bool, error) {
+	max := defaultMaxRetries
+	if v, found := pvc.Annotations[annMaxRetries]; found {
+		if parsed, err := parsePositiveInt(v); err == nil {
+			max = parsed
+		}
+	}
+
+	retryCount := 0
+	if v, found := pvc.Annotations[annRetryCount]; found {
+		if parsed, err := parsePositiveInt(v); err == nil {
+			retryCount = parsed
+		}
+	}
+	if retryCount >= max {
+		glog.Infof("retryImporterPod: pvc %s/%s exhausted its %d retries, leaving it failed\n", pvc.Namespace, pvc.Name, max)
+		return false, nil
+	}
+
+	backoff := backoffDuration(retryCount)
+
+	if err := c.clientset.CoreV1().Pods(pod.Namespace).Delete(pod.Name, &metav1.DeleteOptions{}); err != nil && !apierrs.IsNotFound(err) {
+		return false, fmt.Errorf("retryImporterPod: error deleting failed pod %s/%s: %v\n", pod.Namespace, pod.Name, err)
+	}
+
+	pvcClone := pvc.DeepCopy()
+	metav1.SetMetaDataAnnotation(&pvcClone.ObjectMeta, annRetryCount, fmt.Sprintf("%d", retryCount+1))
+	delete(pvcClone.Annotations, annStatus)
+	if _, err := c.clientset.CoreV1().PersistentVolumeClaims(pvc.Namespace).Update(pvcClone); err != nil {
+		return false, fmt.Errorf("retryImporterPod: error recording retry count on pvc %s/%s: %v\n", pvc.Namespace, pvc.Name, err)
+	}
+
+	glog.Infof("retryImporterPod: retry %d/%d for pvc %s/%s scheduled in %s\n", retryCount+1, max, pvc.Namespace, pvc.Name, backoff)
+	c.queue.AddAfter(pvc.Namespace+"/"+pvc.Name, backoff)
+	return true, nil
+}
+
+// backoffDuration returns the delay before the (retryCount+1)'th retry:
+// 1s, 2s, 4s, 8s, ... doubling with each attempt.
+func backoffDuration(retryCount int) time.Duration {
+	return time.Duration(math.Pow(2, float64(retryCount))) * time.Second
+}
+
+// garbageCollectImporterPod deletes pod once it has been terminal for longer
+// than its configured TTL. Successful pods use c.podGCTTLSucceeded, failed
+// ones (left around for debugging) use the longer c.podGCTTLFailed. A
+// terminal pod emits no further informer events on its own, so until the TTL
+// elapses this requeues the owning pvc key for another look rather than
+// relying on an incidental resync to ever collect it.
+func (c *Controller) garbageCollectImporterPod(pod *v1.Pod, pvc *v1.PersistentVolumeClaim) error {
+	ttl := c.podGCTTLSucceeded
+	if pod.Status.Phase == v1.PodFailed {
+		ttl = c.podGCTTLFailed
+	}
+	if ttl <= 0 {
+		return nil // GC disabled
+	}
+
+	completedAt := podCompletionTime(pod)
+	if completedAt.IsZero() {
+		return nil
+	}
+	if age := time.Since(completedAt); age < ttl {
+		c.queue.AddAfter(pvc.Namespace+"/"+pvc.Name, ttl-age)
+		return nil
+	}
+
+	err := c.clientset.CoreV1().Pods(pod.Namespace).Delete(pod.Name, &metav1.DeleteOptions{})
+	if err != nil && !apierrs.IsNotFound(err) {
+		return fmt.Errorf("garbageCollectImporterPod: error deleting pod %s/%s: %v\n", pod.Namespace, pod.Name, err)
+	}
+	return nil
+}
+
+// podCompletionTime returns the termination time of the pod's container, or
+// the zero Time if it hasn't terminated.
+func podCompletionTime(pod *v1.Pod) time.Time {
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.State.Terminated != nil {
+			return cs.State.Terminated.FinishedAt.Time
+		}
+	}
+	return time.Time{}
+}
+
+// parsePositiveInt parses s as a non-negative integer annotation value.
+func parsePositiveInt(s string) (int, error) {
+	var n int
+	if _, err := fmt.Sscanf(s, "%d", &n); err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("parsePositiveInt: %q is negative", s)
+	}
+	return n, nil
+}
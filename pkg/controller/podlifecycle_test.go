@@ -0,0 +1,99 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPodStatus(t *testing.T) {
+	cases := []struct {
+		name         string
+		phase        v1.PodPhase
+		exitCode     int32
+		wantStatus   string
+		wantExitCode int32
+	}{
+		{name: "succeeded", phase: v1.PodSucceeded, wantStatus: "Succeeded"},
+		{name: "failed", phase: v1.PodFailed, exitCode: 137, wantStatus: "Failed", wantExitCode: 137},
+		{name: "running", phase: v1.PodRunning, wantStatus: ""},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			pod := &v1.Pod{
+				Status: v1.PodStatus{
+					Phase: tc.phase,
+					ContainerStatuses: []v1.ContainerStatus{
+						{
+							State: v1.ContainerState{
+								Terminated: &v1.ContainerStateTerminated{ExitCode: tc.exitCode},
+							},
+						},
+					},
+				},
+			}
+			if tc.phase == v1.PodRunning {
+				pod.Status.ContainerStatuses[0].State = v1.ContainerState{Running: &v1.ContainerStateRunning{}}
+			}
+
+			status, exitCode := podStatus(pod)
+			if status != tc.wantStatus {
+				t.Errorf("status = %q, want %q", status, tc.wantStatus)
+			}
+			if exitCode != tc.wantExitCode {
+				t.Errorf("exitCode = %d, want %d", exitCode, tc.wantExitCode)
+			}
+		})
+	}
+}
+
+func TestBackoffDuration(t *testing.T) {
+	cases := []struct {
+		retryCount int
+		want       time.Duration
+	}{
+		{0, 1 * time.Second},
+		{1, 2 * time.Second},
+		{2, 4 * time.Second},
+		{3, 8 * time.Second},
+	}
+	for _, tc := range cases {
+		if got := backoffDuration(tc.retryCount); got != tc.want {
+			t.Errorf("backoffDuration(%d) = %s, want %s", tc.retryCount, got, tc.want)
+		}
+	}
+}
+
+func TestPodCompletionTime(t *testing.T) {
+	finishedAt := metav1.NewTime(time.Now())
+	pod := &v1.Pod{
+		Status: v1.PodStatus{
+			ContainerStatuses: []v1.ContainerStatus{
+				{State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{FinishedAt: finishedAt}}},
+			},
+		},
+	}
+	if got := podCompletionTime(pod); !got.Equal(finishedAt.Time) {
+		t.Errorf("podCompletionTime = %v, want %v", got, finishedAt.Time)
+	}
+
+	running := &v1.Pod{Status: v1.PodStatus{ContainerStatuses: []v1.ContainerStatus{{State: v1.ContainerState{Running: &v1.ContainerStateRunning{}}}}}}
+	if got := podCompletionTime(running); !got.IsZero() {
+		t.Errorf("podCompletionTime for a running pod = %v, want zero", got)
+	}
+}
+
+func TestParsePositiveInt(t *testing.T) {
+	if n, err := parsePositiveInt("3"); err != nil || n != 3 {
+		t.Errorf("parsePositiveInt(3) = (%d, %v), want (3, nil)", n, err)
+	}
+	if _, err := parsePositiveInt("-1"); err == nil {
+		t.Error("expected an error for a negative value, got nil")
+	}
+	if _, err := parsePositiveInt("not-a-number"); err == nil {
+		t.Error("expected an error for a non-numeric value, got nil")
+	}
+}
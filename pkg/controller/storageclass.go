@@ -0,0 +1,287 @@
+package controller
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// StorageClass parameters recognized by CDI. A cluster admin sets these on a
+// CDI-owned StorageClass so importer behavior can be configured centrally,
+// instead of every user having to annotate their PVC.
+const (
+	scParamEndpoint         = "cdi.kubevirt.io/endpoint"
+	scParamSecretName       = "cdi.kubevirt.io/secretName"
+	scParamSecretNamespace  = "cdi.kubevirt.io/secretNamespace"
+	scParamImporterImage    = "cdi.kubevirt.io/importerImage"
+	scParamImagePullPolicy  = "cdi.kubevirt.io/imagePullPolicy"
+	scParamNodeSelector     = "cdi.kubevirt.io/nodeSelector"
+	scParamTolerations      = "cdi.kubevirt.io/tolerations"
+	scParamResourceRequests = "cdi.kubevirt.io/resourceRequests"
+	scParamResourceLimits   = "cdi.kubevirt.io/resourceLimits"
+)
+
+// templateVarPattern matches ${...} placeholders such as ${pvc.name},
+// ${pvc.namespace}, ${pv.name} and ${pvc.annotations['key']}.
+var templateVarPattern = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+var annotationVarPattern = regexp.MustCompile(`^pvc\.annotations\['(.+)'\]$`)
+
+// importerConfig holds the fully-resolved, per-import configuration used to
+// build the importer pod spec. Values are seeded from the PVC's StorageClass
+// parameters and then overridden by PVC annotations, so annotations always
+// take precedence over cluster-wide defaults.
+type importerConfig struct {
+	Endpoint        string
+	SecretName      string
+	SecretNamespace string
+	ImporterImage   string
+	ImagePullPolicy v1.PullPolicy
+	NodeSelector    map[string]string
+	Tolerations     []v1.Toleration
+	Resources       v1.ResourceRequirements
+	VaultPath       string
+	VaultRole       string
+}
+
+// storageClassForPVC returns the StorageClass referenced by pvc, or nil if the
+// pvc does not reference one. A nil StorageClass means SC-derived defaults do
+// not apply and annotations are the only source of configuration.
+func (c *Controller) storageClassForPVC(pvc *v1.PersistentVolumeClaim) (*storagev1.StorageClass, error) {
+	name := pvc.Spec.StorageClassName
+	if name == nil || *name == "" {
+		return nil, nil
+	}
+	sc, err := c.clientset.StorageV1().StorageClasses().Get(*name, metav1.GetOptions{})
+	if apierrs.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("storageClassForPVC: error getting StorageClass %q for pvc %s/%s: %v\n", *name, pvc.Namespace, pvc.Name, err)
+	}
+	return sc, nil
+}
+
+// substituteTemplate replaces ${pvc.name}, ${pvc.namespace}, ${pv.name}, and
+// ${pvc.annotations['x']} placeholders in tmpl with values taken from pvc. An
+// error is returned if a referenced annotation is missing or the variable is
+// unrecognized, so callers can surface it as a validation error.
+func substituteTemplate(tmpl string, pvc *v1.PersistentVolumeClaim) (string, error) {
+	var substErr error
+	result := templateVarPattern.ReplaceAllStringFunc(tmpl, func(match string) string {
+		if substErr != nil {
+			return match
+		}
+		name := strings.TrimSpace(templateVarPattern.FindStringSubmatch(match)[1])
+		switch name {
+		case "pvc.name":
+			return pvc.Name
+		case "pvc.namespace":
+			return pvc.Namespace
+		case "pv.name":
+			return pvc.Spec.VolumeName
+		default:
+			if m := annotationVarPattern.FindStringSubmatch(name); m != nil {
+				val, found := pvc.Annotations[m[1]]
+				if !found {
+					substErr = fmt.Errorf("substituteTemplate: annotation %q referenced by template %q is missing on pvc %s/%s\n", m[1], tmpl, pvc.Namespace, pvc.Name)
+					return match
+				}
+				return val
+			}
+			substErr = fmt.Errorf("substituteTemplate: unknown template variable %q in %q\n", name, tmpl)
+			return match
+		}
+	})
+	if substErr != nil {
+		return "", substErr
+	}
+	return result, nil
+}
+
+// resolveImporterConfig builds the importerConfig for pvc by starting from
+// its StorageClass parameters (with template substitution applied) and then
+// overriding with any PVC annotations the user set directly. Template
+// substitution errors are surfaced as a Warning event on the pvc.
+func (c *Controller) resolveImporterConfig(pvc *v1.PersistentVolumeClaim) (*importerConfig, error) {
+	cfg := &importerConfig{
+		ImporterImage:   "docker.io/jcoperh/importer:" + c.importerImageTag,
+		ImagePullPolicy: v1.PullAlways,
+	}
+
+	sc, err := c.storageClassForPVC(pvc)
+	if err != nil {
+		return nil, err
+	}
+	if sc != nil {
+		if err := applyStorageClassParams(cfg, sc.Parameters, pvc); err != nil {
+			c.recorder.Event(pvc, v1.EventTypeWarning, "ImporterConfigInvalid", err.Error())
+			return nil, err
+		}
+	}
+
+	if ep, err := getEndpoint(pvc); err == nil && ep != "" {
+		cfg.Endpoint = ep
+	}
+	if name, err := c.getSecretName(pvc); err != nil {
+		return nil, err
+	} else if name != "" {
+		cfg.SecretName = name
+		cfg.SecretNamespace = pvc.Namespace
+	}
+
+	if cfg.Endpoint == "" {
+		return nil, fmt.Errorf("resolveImporterConfig: no endpoint configured via annotation %q or StorageClass parameter %q for pvc %s/%s\n", annEndpoint, scParamEndpoint, pvc.Namespace, pvc.Name)
+	}
+
+	if vaultSecret, err := c.materializeVaultSecret(pvc, cfg); err != nil {
+		return nil, err
+	} else if vaultSecret != "" {
+		// a vault-derived secret always wins over one named by an annotation
+		// or StorageClass parameter, since the user asked for it explicitly.
+		cfg.SecretName = vaultSecret
+		cfg.SecretNamespace = pvc.Namespace
+	}
+
+	return cfg, nil
+}
+
+// applyStorageClassParams templates and copies SC parameters onto cfg.
+func applyStorageClassParams(cfg *importerConfig, params map[string]string, pvc *v1.PersistentVolumeClaim) error {
+	if v, found := params[scParamEndpoint]; found {
+		tv, err := substituteTemplate(v, pvc)
+		if err != nil {
+			return err
+		}
+		cfg.Endpoint = tv
+	}
+	if v, found := params[scParamSecretName]; found {
+		tv, err := substituteTemplate(v, pvc)
+		if err != nil {
+			return err
+		}
+		cfg.SecretName = tv
+		cfg.SecretNamespace = pvc.Namespace
+	}
+	if v, found := params[scParamSecretNamespace]; found {
+		tv, err := substituteTemplate(v, pvc)
+		if err != nil {
+			return err
+		}
+		cfg.SecretNamespace = tv
+	}
+	if v, found := params[scParamImporterImage]; found {
+		cfg.ImporterImage = v
+	}
+	if v, found := params[scParamImagePullPolicy]; found {
+		cfg.ImagePullPolicy = v1.PullPolicy(v)
+	}
+	if v, found := params[scParamNodeSelector]; found {
+		ns, err := parseKeyValueList(v)
+		if err != nil {
+			return fmt.Errorf("applyStorageClassParams: invalid %q: %v\n", scParamNodeSelector, err)
+		}
+		cfg.NodeSelector = ns
+	}
+	if v, found := params[scParamTolerations]; found {
+		tolerations, err := parseTolerations(v)
+		if err != nil {
+			return fmt.Errorf("applyStorageClassParams: invalid %q: %v\n", scParamTolerations, err)
+		}
+		cfg.Tolerations = tolerations
+	}
+	if v, found := params[scParamResourceRequests]; found {
+		requests, err := parseResourceList(v)
+		if err != nil {
+			return fmt.Errorf("applyStorageClassParams: invalid %q: %v\n", scParamResourceRequests, err)
+		}
+		cfg.Resources.Requests = requests
+	}
+	if v, found := params[scParamResourceLimits]; found {
+		limits, err := parseResourceList(v)
+		if err != nil {
+			return fmt.Errorf("applyStorageClassParams: invalid %q: %v\n", scParamResourceLimits, err)
+		}
+		cfg.Resources.Limits = limits
+	}
+	if v, found := params[scParamVaultPath]; found {
+		tv, err := substituteTemplate(v, pvc)
+		if err != nil {
+			return err
+		}
+		cfg.VaultPath = tv
+	}
+	if v, found := params[scParamVaultRole]; found {
+		cfg.VaultRole = v
+	}
+	return nil
+}
+
+// parseKeyValueList parses a comma-separated "k1=v1,k2=v2" string, as used by
+// SC parameters that describe a map.
+func parseKeyValueList(s string) (map[string]string, error) {
+	result := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("expected key=value, got %q", pair)
+		}
+		result[kv[0]] = kv[1]
+	}
+	return result, nil
+}
+
+// parseResourceList parses a comma-separated "cpu=500m,memory=1Gi" string
+// into a v1.ResourceList, as used by scParamResourceRequests/Limits.
+func parseResourceList(s string) (v1.ResourceList, error) {
+	raw, err := parseKeyValueList(s)
+	if err != nil {
+		return nil, err
+	}
+	result := v1.ResourceList{}
+	for name, qty := range raw {
+		q, err := resource.ParseQuantity(qty)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quantity %q for resource %q: %v", qty, name, err)
+		}
+		result[v1.ResourceName(name)] = q
+	}
+	return result, nil
+}
+
+// parseTolerations parses a comma-separated list of "key[=value]:effect"
+// entries into Tolerations. A toleration with a value uses the Equal
+// operator; one without uses Exists.
+func parseTolerations(s string) ([]v1.Toleration, error) {
+	var result []v1.Toleration
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		i := strings.LastIndex(entry, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("expected key[=value]:effect, got %q", entry)
+		}
+		keyValue, effect := entry[:i], entry[i+1:]
+
+		t := v1.Toleration{Effect: v1.TaintEffect(effect)}
+		if kv := strings.SplitN(keyValue, "=", 2); len(kv) == 2 {
+			t.Key, t.Value, t.Operator = kv[0], kv[1], v1.TolerationOpEqual
+		} else {
+			t.Key, t.Operator = keyValue, v1.TolerationOpExists
+		}
+		result = append(result, t)
+	}
+	return result, nil
+}
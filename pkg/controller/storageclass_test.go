@@ -0,0 +1,89 @@
+package controller
+
+import (
+	"testing"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func testPVC() *v1.PersistentVolumeClaim {
+	return &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "foo",
+			Namespace: "bar",
+			Annotations: map[string]string{
+				"custom.example.com/region": "us-east-1",
+			},
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			VolumeName: "pv-123",
+		},
+	}
+}
+
+func TestSubstituteTemplate(t *testing.T) {
+	pvc := testPVC()
+
+	result, err := substituteTemplate("s3://${pvc.annotations['custom.example.com/region']}/${pvc.namespace}/${pvc.name}/${pv.name}", pvc)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "s3://us-east-1/bar/foo/pv-123"
+	if result != want {
+		t.Errorf("got %q, want %q", result, want)
+	}
+}
+
+func TestSubstituteTemplate_MissingAnnotation(t *testing.T) {
+	pvc := testPVC()
+	if _, err := substituteTemplate("${pvc.annotations['does-not-exist']}", pvc); err == nil {
+		t.Error("expected an error for a missing annotation, got nil")
+	}
+}
+
+func TestSubstituteTemplate_UnknownVariable(t *testing.T) {
+	pvc := testPVC()
+	if _, err := substituteTemplate("${pvc.bogus}", pvc); err == nil {
+		t.Error("expected an error for an unknown template variable, got nil")
+	}
+}
+
+func TestParseResourceList(t *testing.T) {
+	rl, err := parseResourceList("cpu=500m,memory=1Gi")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := rl[v1.ResourceCPU]; got.String() != "500m" {
+		t.Errorf("cpu = %q, want 500m", got.String())
+	}
+	if got := rl[v1.ResourceMemory]; got.String() != "1Gi" {
+		t.Errorf("memory = %q, want 1Gi", got.String())
+	}
+}
+
+func TestParseResourceList_InvalidQuantity(t *testing.T) {
+	if _, err := parseResourceList("cpu=not-a-quantity"); err == nil {
+		t.Error("expected an error for an invalid quantity, got nil")
+	}
+}
+
+func TestParseTolerations(t *testing.T) {
+	tolerations, err := parseTolerations("dedicated=infra:NoSchedule,node.kubernetes.io/unreachable:NoExecute")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(tolerations) != 2 {
+		t.Fatalf("got %d tolerations, want 2", len(tolerations))
+	}
+
+	first := tolerations[0]
+	if first.Key != "dedicated" || first.Value != "infra" || first.Operator != v1.TolerationOpEqual || first.Effect != v1.TaintEffectNoSchedule {
+		t.Errorf("unexpected first toleration: %+v", first)
+	}
+
+	second := tolerations[1]
+	if second.Key != "node.kubernetes.io/unreachable" || second.Operator != v1.TolerationOpExists || second.Effect != v1.TaintEffectNoExecute {
+		t.Errorf("unexpected second toleration: %+v", second)
+	}
+}
@@ -12,6 +12,10 @@ import (
 	"k8s.io/apimachinery/pkg/util/wait"
 )
 
+// optionalKeyTrue is used for Secret keys the importer pod can run without,
+// such as a session token that is only present for some credential types.
+var optionalKeyTrue = true
+
 // return a pvc pointer based on the passed-in work queue key.
 func (c *Controller) pvcFromKey(key interface{}) (*v1.PersistentVolumeClaim, error) {
 	keyString, ok := key.(string)
@@ -100,23 +104,28 @@ func (c *Controller) setPVCStatus(pvc *v1.PersistentVolumeClaim, status string)
 	return newPVC, err
 }
 
-// return a pointer to a pod which is created based on the passed-in endpoint, secret
-// name, and pvc. A nil secret means the endpoint credentials are not passed to the
-// importer pod.
-func (c *Controller) createImporterPod(ep, secretName string, pvc *v1.PersistentVolumeClaim) (*v1.Pod, error) {
+// return a pointer to a pod which is created based on the passed-in pvc. The pvc's
+// StorageClass parameters and annotations are resolved into an importerConfig, which
+// determines the endpoint, credentials and pod settings used to build the spec.
+func (c *Controller) createImporterPod(cfg *importerConfig, pvc *v1.PersistentVolumeClaim) (*v1.Pod, error) {
 	ns := pvc.Namespace
-	pod := c.makeImporterPodSpec(ep, secretName, pvc)
-	var err error
+	pod, err := c.makeImporterPodSpec(cfg, pvc)
+	if err != nil {
+		return nil, err
+	}
 	pod, err = c.clientset.CoreV1().Pods(ns).Create(pod)
 	if err != nil {
 		return nil, fmt.Errorf("createImporterPod: Create failed: %v\n", err)
 	}
-	glog.Infof("importer pod \"%s/%s\" (image tag: %q) created\n", pod.Namespace, pod.Name, c.importerImageTag)
+	glog.Infof("importer pod \"%s/%s\" (image: %q) created\n", pod.Namespace, pod.Name, cfg.ImporterImage)
 	return pod, nil
 }
 
-// return the importer pod spec based on the passed-in endpoint, secret and pvc.
-func (c *Controller) makeImporterPodSpec(ep, secret string, pvc *v1.PersistentVolumeClaim) *v1.Pod {
+// return the importer pod spec based on the passed-in config and pvc. If the
+// referenced Secret carries file-based credential keys (e.g. a GCS
+// service-account JSON or a CA bundle), they are projected into the
+// container alongside the usual env vars.
+func (c *Controller) makeImporterPodSpec(cfg *importerConfig, pvc *v1.PersistentVolumeClaim) (*v1.Pod, error) {
 	// importer pod name contains the pvc name
 	podName := fmt.Sprintf("%s-%s", common.IMPORTER_PODNAME, pvc.Name)
 	pod := &v1.Pod{
@@ -129,13 +138,17 @@ func (c *Controller) makeImporterPodSpec(ep, secret string, pvc *v1.PersistentVo
 			Annotations: map[string]string{
 				annCreatedBy: "yes",
 			},
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(pvc, v1.SchemeGroupVersion.WithKind("PersistentVolumeClaim")),
+			},
 		},
 		Spec: v1.PodSpec{
 			Containers: []v1.Container{
 				{
 					Name:            common.IMPORTER_PODNAME,
-					Image:           "docker.io/jcoperh/importer:" + c.importerImageTag,
-					ImagePullPolicy: v1.PullAlways,
+					Image:           cfg.ImporterImage,
+					ImagePullPolicy: cfg.ImagePullPolicy,
+					Resources:       cfg.Resources,
 					VolumeMounts: []v1.VolumeMount{
 						{
 							Name:      "data-path",
@@ -145,6 +158,8 @@ func (c *Controller) makeImporterPodSpec(ep, secret string, pvc *v1.PersistentVo
 				},
 			},
 			RestartPolicy: v1.RestartPolicyNever,
+			NodeSelector:  cfg.NodeSelector,
+			Tolerations:   cfg.Tolerations,
 			Volumes: []v1.Volume{
 				{
 					Name: "data-path",
@@ -158,8 +173,26 @@ func (c *Controller) makeImporterPodSpec(ep, secret string, pvc *v1.PersistentVo
 			},
 		},
 	}
-	pod.Spec.Containers[0].Env = makeEnv(ep, secret)
-	return pod
+	pod.Spec.Containers[0].Env = makeEnv(cfg.Endpoint, cfg.SecretName)
+
+	if cfg.SecretName != "" {
+		secret, err := c.clientset.CoreV1().Secrets(cfg.SecretNamespace).Get(cfg.SecretName, metav1.GetOptions{})
+		if err != nil && !apierrs.IsNotFound(err) {
+			return nil, fmt.Errorf("makeImporterPodSpec: error getting secret %s/%s: %v\n", cfg.SecretNamespace, cfg.SecretName, err)
+		}
+		projection, err := c.buildFileCredentialProjection(secret, pvc)
+		if err != nil {
+			return nil, err
+		}
+		if projection != nil {
+			pod.Spec.Volumes = append(pod.Spec.Volumes, *projection.Volume)
+			container := &pod.Spec.Containers[0]
+			container.VolumeMounts = append(container.VolumeMounts, projection.VolumeMount)
+			container.Env = append(container.Env, projection.Env...)
+		}
+	}
+
+	return pod, nil
 }
 
 // return the Env portion for the importer container.
@@ -171,6 +204,9 @@ func makeEnv(endpoint, secret string) []v1.EnvVar {
 		},
 	}
 	if secret != "" {
+		// Optional: a file-only credential Secret (e.g. a GCS service-account
+		// JSON or a CA-only bundle) has neither key, and the pod must still
+		// start in that case.
 		env = append(env, v1.EnvVar{
 			Name: common.IMPORTER_ACCESS_KEY_ID,
 			ValueFrom: &v1.EnvVarSource{
@@ -178,7 +214,8 @@ func makeEnv(endpoint, secret string) []v1.EnvVar {
 					LocalObjectReference: v1.LocalObjectReference{
 						Name: secret,
 					},
-					Key: common.KeyAccess,
+					Key:      common.KeyAccess,
+					Optional: &optionalKeyTrue,
 				},
 			},
 		}, v1.EnvVar{
@@ -188,7 +225,21 @@ func makeEnv(endpoint, secret string) []v1.EnvVar {
 					LocalObjectReference: v1.LocalObjectReference{
 						Name: secret,
 					},
-					Key: common.KeySecret,
+					Key:      common.KeySecret,
+					Optional: &optionalKeyTrue,
+				},
+			},
+		}, v1.EnvVar{
+			// optional: only set for credentials that include a session token,
+			// such as those backed by Vault dynamic/STS credentials.
+			Name: common.IMPORTER_SESSION_TOKEN,
+			ValueFrom: &v1.EnvVarSource{
+				SecretKeyRef: &v1.SecretKeySelector{
+					LocalObjectReference: v1.LocalObjectReference{
+						Name: secret,
+					},
+					Key:      common.KeySessionToken,
+					Optional: &optionalKeyTrue,
 				},
 			},
 		})
@@ -0,0 +1,175 @@
+package controller
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+	"github.com/kubevirt/containerized-data-importer/pkg/common"
+	"k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// annotations a PVC (or its StorageClass, via scParamVaultPath/scParamVaultRole)
+// can set to source endpoint credentials from Vault instead of, or in addition
+// to, a plain Kubernetes Secret.
+const (
+	annVaultPath = "cdi.kubevirt.io/vaultPath"
+	annVaultRole = "cdi.kubevirt.io/vaultRole"
+
+	scParamVaultPath = "cdi.kubevirt.io/vaultPath"
+	scParamVaultRole = "cdi.kubevirt.io/vaultRole"
+
+	// annVaultLeaseID records the lease backing an ephemeral vault-derived
+	// Secret so it can be revoked once the importer pod finishes with it.
+	annVaultLeaseID = "cdi.kubevirt.io/vaultLeaseID"
+
+	saTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+)
+
+// vaultSecretName returns the name of the ephemeral Secret materialized from
+// Vault for pvc.
+func vaultSecretName(pvc *v1.PersistentVolumeClaim) string {
+	return fmt.Sprintf("%s-vault-creds", pvc.Name)
+}
+
+// materializeVaultSecret reads the Vault KV path named by the pvc's
+// cdi.kubevirt.io/vaultPath annotation (falling back to the StorageClass
+// parameter of the same name) and writes its contents into an ephemeral,
+// owner-referenced Secret in the pvc's namespace. It returns the name of that
+// Secret, or "" if the pvc does not request Vault-backed credentials.
+func (c *Controller) materializeVaultSecret(pvc *v1.PersistentVolumeClaim, cfg *importerConfig) (string, error) {
+	path, role, err := vaultPathAndRole(pvc, cfg)
+	if err != nil {
+		return "", err
+	}
+	if path == "" {
+		return "", nil // no vault credentials requested
+	}
+	if c.vaultClient == nil {
+		return "", fmt.Errorf("materializeVaultSecret: pvc %s/%s requests vault path %q but no vault client is configured\n", pvc.Namespace, pvc.Name, path)
+	}
+
+	if err := c.vaultClient.EnsureLoggedIn(saTokenPath, role); err != nil {
+		c.recorder.Eventf(pvc, v1.EventTypeWarning, "VaultLoginFailed", "%v", err)
+		return "", fmt.Errorf("materializeVaultSecret: %v\n", err)
+	}
+
+	data, leaseID, err := c.vaultClient.ReadKV(path)
+	if err != nil {
+		c.recorder.Eventf(pvc, v1.EventTypeWarning, "VaultReadFailed", "%v", err)
+		return "", fmt.Errorf("materializeVaultSecret: %v\n", err)
+	}
+
+	secretData, err := vaultDataToSecretData(data)
+	if err != nil {
+		return "", fmt.Errorf("materializeVaultSecret: pvc %s/%s: %v\n", pvc.Namespace, pvc.Name, err)
+	}
+
+	name := vaultSecretName(pvc)
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: pvc.Namespace,
+			Labels: map[string]string{
+				annCreatedBy: "yes",
+			},
+			Annotations: map[string]string{
+				annVaultLeaseID: leaseID,
+			},
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(pvc, v1.SchemeGroupVersion.WithKind("PersistentVolumeClaim")),
+			},
+		},
+		Type:       v1.SecretTypeOpaque,
+		StringData: secretData,
+	}
+
+	_, err = c.clientset.CoreV1().Secrets(pvc.Namespace).Create(secret)
+	if apierrs.IsAlreadyExists(err) {
+		glog.Infof("materializeVaultSecret: ephemeral secret %s/%s already exists, reusing it\n", pvc.Namespace, name)
+		return name, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("materializeVaultSecret: error creating ephemeral secret %s/%s: %v\n", pvc.Namespace, name, err)
+	}
+	return name, nil
+}
+
+// vaultPathAndRole resolves the vault path/role to use for pvc, preferring
+// the PVC's own annotations over the StorageClass parameters captured in cfg.
+func vaultPathAndRole(pvc *v1.PersistentVolumeClaim, cfg *importerConfig) (path, role string, err error) {
+	path = pvc.Annotations[annVaultPath]
+	role = pvc.Annotations[annVaultRole]
+	if path == "" && cfg != nil {
+		path = cfg.VaultPath
+		role = cfg.VaultRole
+	}
+	return path, role, nil
+}
+
+// vaultDataToSecretData maps well-known Vault KV keys onto the Secret keys
+// makeEnv expects (access key / secret key / session token).
+func vaultDataToSecretData(data map[string]interface{}) (map[string]string, error) {
+	result := map[string]string{}
+	keys := map[string]string{
+		"access_key":    common.KeyAccess,
+		"secret_key":    common.KeySecret,
+		"session_token": common.KeySessionToken,
+	}
+	for vaultKey, secretKey := range keys {
+		v, found := data[vaultKey]
+		if !found {
+			continue
+		}
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("vault key %q is not a string", vaultKey)
+		}
+		result[secretKey] = s
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("none of the expected keys (access_key, secret_key, session_token) were found")
+	}
+	return result, nil
+}
+
+// revokeVaultLease revokes the Vault lease backing an ephemeral secret once
+// the importer pod that used it has completed, so the credential cannot be
+// reused afterwards.
+func (c *Controller) revokeVaultLease(secret *v1.Secret) {
+	if c.vaultClient == nil {
+		return
+	}
+	leaseID := secret.Annotations[annVaultLeaseID]
+	if leaseID == "" {
+		return
+	}
+	if err := c.vaultClient.RevokeLease(leaseID); err != nil {
+		glog.Warningf("revokeVaultLease: %v\n", err)
+	}
+}
+
+// cleanupVaultSecret revokes the Vault lease backing pvc's ephemeral
+// vault-creds Secret, if any, and deletes the Secret itself. It is called
+// once the importer pod that consumed it reaches a terminal phase, so a
+// credential is never left usable (or lying around) longer than the import
+// that needed it.
+func (c *Controller) cleanupVaultSecret(pvc *v1.PersistentVolumeClaim) error {
+	name := vaultSecretName(pvc)
+	secret, err := c.clientset.CoreV1().Secrets(pvc.Namespace).Get(name, metav1.GetOptions{})
+	if apierrs.IsNotFound(err) {
+		return nil // pvc did not use vault-backed credentials
+	}
+	if err != nil {
+		return fmt.Errorf("cleanupVaultSecret: error getting ephemeral secret %s/%s: %v\n", pvc.Namespace, name, err)
+	}
+
+	c.revokeVaultLease(secret)
+
+	err = c.clientset.CoreV1().Secrets(pvc.Namespace).Delete(name, &metav1.DeleteOptions{})
+	if err != nil && !apierrs.IsNotFound(err) {
+		return fmt.Errorf("cleanupVaultSecret: error deleting ephemeral secret %s/%s: %v\n", pvc.Namespace, name, err)
+	}
+	return nil
+}
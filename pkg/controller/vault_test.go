@@ -0,0 +1,67 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/kubevirt/containerized-data-importer/pkg/common"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestVaultDataToSecretData(t *testing.T) {
+	data, err := vaultDataToSecretData(map[string]interface{}{
+		"access_key": "AKIA...",
+		"secret_key": "shh",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if data[common.KeyAccess] != "AKIA..." || data[common.KeySecret] != "shh" {
+		t.Errorf("unexpected secret data: %+v", data)
+	}
+}
+
+func TestVaultDataToSecretData_NoRecognizedKeys(t *testing.T) {
+	if _, err := vaultDataToSecretData(map[string]interface{}{"unrelated": "value"}); err == nil {
+		t.Error("expected an error when no recognized keys are present, got nil")
+	}
+}
+
+func TestVaultDataToSecretData_NonStringValue(t *testing.T) {
+	if _, err := vaultDataToSecretData(map[string]interface{}{"access_key": 42}); err == nil {
+		t.Error("expected an error for a non-string value, got nil")
+	}
+}
+
+func TestVaultPathAndRole_AnnotationWinsOverConfig(t *testing.T) {
+	pvc := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				annVaultPath: "secret/data/cdi/myendpoint",
+				annVaultRole: "cdi-importer",
+			},
+		},
+	}
+	cfg := &importerConfig{VaultPath: "secret/data/cdi/other", VaultRole: "other-role"}
+
+	path, role, err := vaultPathAndRole(pvc, cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if path != "secret/data/cdi/myendpoint" || role != "cdi-importer" {
+		t.Errorf("got path=%q role=%q, want the pvc annotation values", path, role)
+	}
+}
+
+func TestVaultPathAndRole_FallsBackToConfig(t *testing.T) {
+	pvc := &v1.PersistentVolumeClaim{}
+	cfg := &importerConfig{VaultPath: "secret/data/cdi/other", VaultRole: "other-role"}
+
+	path, role, err := vaultPathAndRole(pvc, cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if path != cfg.VaultPath || role != cfg.VaultRole {
+		t.Errorf("got path=%q role=%q, want the StorageClass-derived values", path, role)
+	}
+}
@@ -0,0 +1,170 @@
+// Package vault provides a small client used by the CDI controller to fetch
+// endpoint credentials out of HashiCorp Vault, as an alternative to a plain
+// Kubernetes Secret.
+package vault
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	vaultapi "github.com/hashicorp/vault/api"
+)
+
+// Config holds the controller-wide Vault settings, normally populated from
+// controller flags/env (vault address, CA bundle, auth mount path and the
+// default role used when a PVC does not set its own).
+type Config struct {
+	Address       string
+	CACert        string
+	AuthMountPath string
+	DefaultRole   string
+}
+
+// Client authenticates to Vault via the kubernetes auth method and reads KV
+// secrets on behalf of the controller. It caches its login across calls and
+// keeps the token alive with a self-rescheduling renewal loop, so callers
+// don't need to re-authenticate on every import.
+type Client struct {
+	api *vaultapi.Client
+	cfg Config
+
+	mu            sync.Mutex
+	authenticated bool
+	loggedInRole  string
+}
+
+// NewClient builds a Vault API client from cfg. It does not log in; call
+// EnsureLoggedIn before issuing any reads.
+func NewClient(cfg Config) (*Client, error) {
+	vcfg := vaultapi.DefaultConfig()
+	vcfg.Address = cfg.Address
+	if cfg.CACert != "" {
+		if err := vcfg.ConfigureTLS(&vaultapi.TLSConfig{CACert: cfg.CACert}); err != nil {
+			return nil, fmt.Errorf("vault.NewClient: error configuring TLS: %v", err)
+		}
+	}
+	api, err := vaultapi.NewClient(vcfg)
+	if err != nil {
+		return nil, fmt.Errorf("vault.NewClient: error creating client: %v", err)
+	}
+	return &Client{api: api, cfg: cfg}, nil
+}
+
+// EnsureLoggedIn authenticates to Vault's kubernetes auth method using the
+// ServiceAccount token at saTokenPath, requesting the named role (falling
+// back to cfg.DefaultRole when role is empty), unless the client is already
+// authenticated for that role. The first successful login for a role starts
+// a self-rescheduling renewal loop that keeps the token alive for as long as
+// the client lives, so callers don't need to re-authenticate on every call.
+func (c *Client) EnsureLoggedIn(saTokenPath, role string) error {
+	if role == "" {
+		role = c.cfg.DefaultRole
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.authenticated && c.loggedInRole == role {
+		return nil
+	}
+
+	leaseDuration, err := c.login(saTokenPath, role)
+	if err != nil {
+		return err
+	}
+	c.authenticated = true
+	c.loggedInRole = role
+	c.scheduleRenewal(saTokenPath, role, leaseDuration)
+	return nil
+}
+
+// login performs a single Vault kubernetes-auth login and sets the resulting
+// token on the underlying client, returning its lease duration.
+func (c *Client) login(saTokenPath, role string) (leaseDuration int, err error) {
+	jwt, err := ioutil.ReadFile(saTokenPath)
+	if err != nil {
+		return 0, fmt.Errorf("vault.login: error reading ServiceAccount token %q: %v", saTokenPath, err)
+	}
+	mount := c.cfg.AuthMountPath
+	if mount == "" {
+		mount = "kubernetes"
+	}
+	secret, err := c.api.Logical().Write(fmt.Sprintf("auth/%s/login", mount), map[string]interface{}{
+		"jwt":  string(jwt),
+		"role": role,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("vault.login: error logging in via %q with role %q: %v", mount, role, err)
+	}
+	if secret == nil || secret.Auth == nil {
+		return 0, fmt.Errorf("vault.login: no auth info returned for role %q", role)
+	}
+	c.api.SetToken(secret.Auth.ClientToken)
+	glog.Infof("vault.login: authenticated with role %q, lease duration %ds\n", role, secret.Auth.LeaseDuration)
+	return secret.Auth.LeaseDuration, nil
+}
+
+// scheduleRenewal arranges for the client's token to be renewed at the
+// midpoint of its lease, and reschedules itself after each successful
+// renewal so the token stays valid indefinitely. If a renewal fails, the
+// client is marked unauthenticated so the next EnsureLoggedIn call re-logs
+// in rather than looping on a dead token.
+func (c *Client) scheduleRenewal(saTokenPath, role string, leaseDuration int) {
+	if leaseDuration <= 0 {
+		return
+	}
+	renewAfter := time.Duration(leaseDuration/2) * time.Second
+	time.AfterFunc(renewAfter, func() {
+		if err := c.RenewToken(leaseDuration); err != nil {
+			glog.Warningf("vault.scheduleRenewal: %v; will re-login on next use\n", err)
+			c.mu.Lock()
+			c.authenticated = false
+			c.mu.Unlock()
+			return
+		}
+		c.scheduleRenewal(saTokenPath, role, leaseDuration)
+	})
+}
+
+// ReadKV reads the KV v2 secret at path and returns its data map along with
+// the lease ID, if any, so the caller can revoke it once it is no longer
+// needed.
+func (c *Client) ReadKV(path string) (data map[string]interface{}, leaseID string, err error) {
+	secret, err := c.api.Logical().Read(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("vault.ReadKV: error reading %q: %v", path, err)
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, "", fmt.Errorf("vault.ReadKV: no data found at %q", path)
+	}
+	// KV v2 nests the actual secret data under a "data" key.
+	if nested, ok := secret.Data["data"].(map[string]interface{}); ok {
+		return nested, secret.LeaseID, nil
+	}
+	return secret.Data, secret.LeaseID, nil
+}
+
+// RevokeLease revokes the lease obtained when reading a secret, so the
+// credential stops being valid once the importer pod is done with it. A
+// blank leaseID is a no-op, since not every Vault secret engine issues one.
+func (c *Client) RevokeLease(leaseID string) error {
+	if leaseID == "" {
+		return nil
+	}
+	if err := c.api.Sys().Revoke(leaseID); err != nil {
+		return fmt.Errorf("vault.RevokeLease: error revoking lease %q: %v", leaseID, err)
+	}
+	return nil
+}
+
+// RenewToken renews the client's own login token for increment seconds, so
+// long-running controllers don't need to re-login on every reconcile.
+func (c *Client) RenewToken(increment int) error {
+	_, err := c.api.Auth().Token().RenewSelf(increment)
+	if err != nil {
+		return fmt.Errorf("vault.RenewToken: error renewing token: %v", err)
+	}
+	return nil
+}